@@ -15,8 +15,6 @@
 package epic
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
 	"crypto/subtle"
 	"encoding/binary"
 	"math"
@@ -67,50 +65,91 @@ func CreateTimestamp(input time.Time, now time.Time) (uint32, error) {
 // does not date back more than the maximal packet lifetime of two seconds. The function also takes
 // a possible clock drift between the packet source and the verifier of up to one second into
 // account.
+//
+// VerifyTimestamp is equivalent to calling VerifyTimestampInWindow with DefaultAcceptanceWindow.
 func VerifyTimestamp(timestamp time.Time, epicTS uint32, now time.Time) error {
+	return VerifyTimestampInWindow(timestamp, epicTS, now, DefaultAcceptanceWindow)
+}
+
+// AcceptanceWindow configures the interval, relative to the verifier's current time, in which an
+// EPIC packet's derived send time must fall to be considered fresh. The accepted interval is
+// [now-Offset, now-Offset+Width]. Operators can widen Width to tolerate geographically distant
+// ASes with more clock drift and network delay, or narrow it on high-throughput border routers
+// that want to shed stale packets more aggressively.
+type AcceptanceWindow struct {
+	// Offset shifts the lower bound of the window back from now.
+	Offset time.Duration
+	// Width is the size of the accepted window.
+	Width time.Duration
+}
+
+// DefaultAcceptanceWindow reproduces the original
+// MaxPacketLifetime/MaxClockSkew window used by VerifyTimestamp: a packet's derived send time
+// must be no older than MaxPacketLifetime+MaxClockSkew and no more than MaxClockSkew in the
+// future.
+var DefaultAcceptanceWindow = AcceptanceWindow{
+	Offset: MaxPacketLifetime + MaxClockSkew,
+	Width:  MaxPacketLifetime + 2*MaxClockSkew,
+}
+
+// VerifyTimestampInWindow checks whether an EPIC packet is fresh, like VerifyTimestamp, but
+// against a caller-supplied AcceptanceWindow instead of the fixed default one.
+func VerifyTimestampInWindow(timestamp time.Time, epicTS uint32, now time.Time,
+	aw AcceptanceWindow) error {
+
 	diff := (time.Duration(epicTS) + 1) * TimestampResolution
 	tsSender := timestamp.Add(diff)
 
-	if tsSender.After(now.Add(MaxClockSkew)) {
-		delta := tsSender.Sub(now.Add(MaxClockSkew))
+	lower := now.Add(-aw.Offset)
+	upper := lower.Add(aw.Width)
+
+	if tsSender.After(upper) {
+		delta := tsSender.Sub(upper)
 		return serrors.New("epic timestamp is in the future",
 			"delta", delta)
 	}
-	if now.After(tsSender.Add(MaxPacketLifetime).Add(MaxClockSkew)) {
-		delta := now.Sub(tsSender.Add(MaxPacketLifetime).Add(MaxClockSkew))
+	if tsSender.Before(lower) {
+		delta := lower.Sub(tsSender)
 		return serrors.New("epic timestamp expired",
 			"delta", delta)
 	}
 	return nil
 }
 
+// VerifyOptions bundles the per-packet verification configuration that callers of
+// VerifyTimestampInWindow and VerifyHVF thread through together, such as the router's EPIC
+// processor: which acceptance window to use for freshness, and which MAC algorithm option(s) to
+// apply to the HVF check.
+type VerifyOptions struct {
+	AcceptanceWindow AcceptanceWindow
+	MACOptions       []Option
+}
+
 // CalcMac derives the EPIC MAC (PHVF/LHVF) given the full 16 bytes of the SCION path type
 // MAC (auth), the EPIC packet ID (pktID), the timestamp in the Info Field (timestamp),
 // and the SCION common/address header (s).
-// If the same buffer is provided in subsequent calls to this function, the previously returned
-// EPIC MAC may get overwritten. Only the most recently returned EPIC MAC is guaranteed to be
-// valid.
+// By default, the MAC is computed with DefaultMACAlgorithm; pass WithMACAlgorithm to select a
+// different one (e.g. CMACv1).
+// MACBufferSize bytes fit the MAC input for every source address length (up to 16 bytes), so
+// passing a buffer of at least that size avoids the allocation below.
 func CalcMac(auth []byte, pktID epic.PktID, s *slayers.SCION,
-	timestamp uint32, buffer []byte) ([]byte, error) {
+	timestamp uint32, buffer []byte, opts ...Option) ([]byte, error) {
 
 	if len(buffer) < MACBufferSize {
 		buffer = make([]byte, MACBufferSize)
 	}
+	o := applyOptions(opts)
 
-	// Initialize cryptographic MAC function
-	f, err := initEpicMac(auth)
+	// Prepare the input for the MAC function
+	inputLength, err := prepareMacInput(pktID, s, timestamp, buffer)
 	if err != nil {
 		return nil, err
 	}
-	// Prepare the input for the MAC function
-	inputLength, err := prepareMacInput(pktID, s, timestamp, buffer)
+	mac, err := o.alg.MAC(auth, buffer[:inputLength])
 	if err != nil {
 		return nil, err
 	}
-	// Calculate Epic MAC = first 4 bytes of the last CBC block
-	input := buffer[:inputLength]
-	f.CryptBlocks(input, input)
-	return input[len(input)-f.BlockSize() : len(input)-f.BlockSize()+4], nil
+	return mac[:], nil
 }
 
 // VerifyHVF verifies the correctness of the HVF (PHVF or the LHVF) field in the EPIC packet by
@@ -118,23 +157,36 @@ func CalcMac(auth []byte, pktID epic.PktID, s *slayers.SCION,
 // bytes of the SCION path type MAC, has invalid length, or if the MAC calculation gives an error,
 // also VerifyHVF returns an error. The verification was successful if and only if VerifyHVF
 // returns nil.
+// By default, verification is attempted with DefaultMACAlgorithm. Pass WithMACAlgorithm to
+// change the primary algorithm, and WithFallbackMACAlgorithm to additionally retry with a second
+// algorithm if the primary one does not validate, e.g. while migrating a deployment from
+// CBCMACv0 to CMACv1.
 func VerifyHVF(auth []byte, pktID epic.PktID, s *slayers.SCION,
-	timestamp uint32, hvf []byte, buffer []byte) error {
+	timestamp uint32, hvf []byte, buffer []byte, opts ...Option) error {
 
 	if s == nil || len(auth) != AuthLen {
 		return serrors.New("invalid input")
 	}
+	o := applyOptions(opts)
 
-	mac, err := CalcMac(auth, pktID, s, timestamp, buffer)
+	mac, err := CalcMac(auth, pktID, s, timestamp, buffer, WithMACAlgorithm(o.alg))
 	if err != nil {
 		return err
 	}
-
-	if subtle.ConstantTimeCompare(hvf, mac) == 0 {
-		return serrors.New("epic hop validation field verification failed",
-			"hvf in packet", hvf, "calculated mac", mac, "auth", auth)
+	if subtle.ConstantTimeCompare(hvf, mac) == 1 {
+		return nil
 	}
-	return nil
+	if o.fallback != nil {
+		fallbackMac, err := CalcMac(auth, pktID, s, timestamp, buffer, WithMACAlgorithm(o.fallback))
+		if err != nil {
+			return err
+		}
+		if subtle.ConstantTimeCompare(hvf, fallbackMac) == 1 {
+			return nil
+		}
+	}
+	return serrors.New("epic hop validation field verification failed",
+		"hvf in packet", hvf, "calculated mac", mac, "auth", auth)
 }
 
 // PktCounterFromCore creates a counter for the packet identifier
@@ -151,17 +203,6 @@ func CoreFromPktCounter(counter uint32) (uint8, uint32) {
 	return coreID, coreCounter
 }
 
-func initEpicMac(key []byte) (cipher.BlockMode, error) {
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, serrors.New("Unable to initialize AES cipher")
-	}
-
-	// CBC-MAC = CBC-Encryption with zero initialization vector
-	mode := cipher.NewCBCEncrypter(block, zeroInitVector[:])
-	return mode, nil
-}
-
 func prepareMacInput(pktID epic.PktID, s *slayers.SCION, timestamp uint32,
 	inputBuffer []byte) (int, error) {
 
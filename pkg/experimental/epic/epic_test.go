@@ -0,0 +1,68 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package epic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyTimestampInWindow(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	aw := AcceptanceWindow{Offset: 2 * time.Second, Width: 3 * time.Second}
+	// Accepted tsSender interval is [now-2s, now+1s].
+
+	testCases := map[string]struct {
+		tsSender  time.Time
+		assertErr assert.ErrorAssertionFunc
+	}{
+		"lower edge accepted":       {now.Add(-2 * time.Second), assert.NoError},
+		"upper edge accepted":       {now.Add(1 * time.Second), assert.NoError},
+		"one tick below lower edge": {now.Add(-2*time.Second - TimestampResolution), assert.Error},
+		"one tick above upper edge": {now.Add(1*time.Second + TimestampResolution), assert.Error},
+		"well within window":        {now, assert.NoError},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			epicTS, input := encodeTimestamp(t, tc.tsSender)
+			err := VerifyTimestampInWindow(input, epicTS, now, aw)
+			tc.assertErr(t, err)
+		})
+	}
+}
+
+func TestVerifyTimestampMatchesDefaultWindow(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	input := now.Add(-MaxPacketLifetime)
+	epicTS, adjustedInput := encodeTimestamp(t, input)
+
+	assert.NoError(t, VerifyTimestamp(adjustedInput, epicTS, now))
+	assert.NoError(t, VerifyTimestampInWindow(adjustedInput, epicTS, now, DefaultAcceptanceWindow))
+}
+
+// encodeTimestamp returns an (epicTS, input) pair such that the derived send time
+// (input + (epicTS+1)*TimestampResolution) equals wantSender, rounded down to the
+// timestamp's resolution.
+func encodeTimestamp(t *testing.T, wantSender time.Time) (uint32, time.Time) {
+	t.Helper()
+	input := wantSender.Add(-TimestampResolution)
+	epicTS, err := CreateTimestamp(input, wantSender)
+	if err != nil {
+		t.Fatalf("creating test timestamp: %v", err)
+	}
+	return epicTS, input
+}
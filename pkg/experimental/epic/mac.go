@@ -0,0 +1,202 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package epic
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+
+	"github.com/scionproto/scion/pkg/private/serrors"
+)
+
+// MACAlgorithm computes the 4-byte EPIC MAC (PHVF/LHVF) over an already
+// prepared, block-aligned input (see prepareMacInput), given the full
+// 16-byte SCION path type MAC as the authentication key. Implementations
+// must be safe to use from multiple goroutines provided each call is given
+// its own input buffer.
+type MACAlgorithm interface {
+	// Name returns a short, stable identifier for the algorithm, used to
+	// select it via SelectMACAlgorithm and in diagnostics.
+	Name() string
+	// MAC computes the EPIC MAC over input, which has already been padded
+	// to a multiple of the AES block size by prepareMacInput.
+	MAC(auth []byte, input []byte) ([4]byte, error)
+}
+
+// CBCMACv0 is the original EPIC MAC algorithm: CBC-encryption with a zero
+// initialization vector, keeping the first 4 bytes of the last ciphertext
+// block. It is kept for backward compatibility with peers that have not yet
+// migrated to CMACv1, and is not recommended for new deployments since
+// CBC-MAC is only secure for fixed-length messages, a property that must be
+// (and, in the EPIC input layout, is) enforced by the caller.
+type CBCMACv0 struct{}
+
+// Name implements MACAlgorithm.
+func (CBCMACv0) Name() string { return "CBC-MACv0" }
+
+// MAC implements MACAlgorithm.
+func (CBCMACv0) MAC(auth []byte, input []byte) ([4]byte, error) {
+	var out [4]byte
+	block, err := aes.NewCipher(auth)
+	if err != nil {
+		return out, serrors.New("unable to initialize AES cipher")
+	}
+	mode := cipher.NewCBCEncrypter(block, zeroInitVector[:])
+	mode.CryptBlocks(input, input)
+	copy(out[:], input[len(input)-block.BlockSize():len(input)-block.BlockSize()+4])
+	return out, nil
+}
+
+// CMACv1 computes the EPIC MAC using RFC 4493 AES-CMAC, keeping the first 4
+// bytes of the resulting tag. Unlike CBCMACv0, CMAC is a secure MAC for
+// variable-length messages, which removes the implicit assumption that
+// every caller always feeds it the same number of blocks.
+//
+// Because prepareMacInput always produces a complete, non-empty sequence of
+// 16-byte blocks (the EPIC input layout zero-pads up to the next block
+// boundary rather than relying on CMAC's own bit-padding), the last block is
+// always "complete" in RFC 4493 terms, so CMACv1 only ever needs the K1
+// subkey, never the K2 padding-oracle-avoidance subkey. It is still derived
+// for clarity and in case future callers pass a non-block-aligned input.
+type CMACv1 struct{}
+
+// Name implements MACAlgorithm.
+func (CMACv1) Name() string { return "CMACv1" }
+
+// MAC implements MACAlgorithm.
+func (CMACv1) MAC(auth []byte, input []byte) ([4]byte, error) {
+	var out [4]byte
+	if len(input) == 0 {
+		return out, serrors.New("CMAC input must not be empty")
+	}
+	block, err := aes.NewCipher(auth)
+	if err != nil {
+		return out, serrors.New("unable to initialize AES cipher")
+	}
+	k1, k2 := cmacSubkeys(block)
+
+	n := (len(input) + 15) / 16
+	complete := len(input)%16 == 0
+
+	x := make([]byte, block.BlockSize())
+	for i := 0; i < n-1; i++ {
+		xorBlockInto(x, input[i*16:(i+1)*16])
+		block.Encrypt(x, x)
+	}
+
+	last := make([]byte, block.BlockSize())
+	if complete {
+		copy(last, input[(n-1)*16:])
+		xorBlockInto(last, k1)
+	} else {
+		tail := input[(n-1)*16:]
+		copy(last, tail)
+		last[len(tail)] = 0x80
+		xorBlockInto(last, k2)
+	}
+	xorBlockInto(x, last)
+	block.Encrypt(x, x)
+	copy(out[:], x[:4])
+	return out, nil
+}
+
+// cmacSubkeys derives the RFC 4493 K1/K2 subkeys from the given cipher.
+func cmacSubkeys(block cipher.Block) (k1, k2 []byte) {
+	const rb = 0x87 // R_128, see RFC 4493 section 2.3
+
+	l := make([]byte, block.BlockSize())
+	block.Encrypt(l, l)
+
+	k1 = leftShiftOne(l)
+	if l[0]&0x80 != 0 {
+		k1[len(k1)-1] ^= rb
+	}
+
+	k2 = leftShiftOne(k1)
+	if k1[0]&0x80 != 0 {
+		k2[len(k2)-1] ^= rb
+	}
+	return k1, k2
+}
+
+// leftShiftOne returns in shifted left by one bit, as used by the RFC 4493
+// subkey derivation.
+func leftShiftOne(in []byte) []byte {
+	out := make([]byte, len(in))
+	var carry byte
+	for i := len(in) - 1; i >= 0; i-- {
+		out[i] = in[i]<<1 | carry
+		carry = in[i] >> 7
+	}
+	return out
+}
+
+// xorBlockInto XORs src into dst in place.
+func xorBlockInto(dst, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
+
+// DefaultMACAlgorithm is the algorithm used when no Option selects one
+// explicitly. It defaults to CBCMACv0 so that existing deployments keep
+// working until they are explicitly configured to use CMACv1.
+var DefaultMACAlgorithm MACAlgorithm = CBCMACv0{}
+
+// SelectMACAlgorithm returns the MACAlgorithm registered under name, so that
+// routers and tooling can negotiate which algorithm to use from a plain
+// string (e.g. read from configuration).
+func SelectMACAlgorithm(name string) (MACAlgorithm, error) {
+	switch name {
+	case CBCMACv0{}.Name():
+		return CBCMACv0{}, nil
+	case CMACv1{}.Name():
+		return CMACv1{}, nil
+	default:
+		return nil, serrors.New("unknown EPIC MAC algorithm", "name", name)
+	}
+}
+
+// options holds the per-call configuration assembled from a list of Option.
+type options struct {
+	alg      MACAlgorithm
+	fallback MACAlgorithm
+}
+
+// Option configures the MAC algorithm used by a single CalcMac/VerifyHVF
+// call, overriding the package-level DefaultMACAlgorithm.
+type Option func(*options)
+
+// WithMACAlgorithm selects alg as the primary MAC algorithm for this call.
+func WithMACAlgorithm(alg MACAlgorithm) Option {
+	return func(o *options) { o.alg = alg }
+}
+
+// WithFallbackMACAlgorithm configures alg to be tried by VerifyHVF if
+// verification with the primary algorithm fails, e.g. while a deployment is
+// being migrated from CBCMACv0 to CMACv1 and peers may still use either one.
+// It has no effect on CalcMac, which only ever computes the primary
+// algorithm's MAC.
+func WithFallbackMACAlgorithm(alg MACAlgorithm) Option {
+	return func(o *options) { o.fallback = alg }
+}
+
+func applyOptions(opts []Option) options {
+	o := options{alg: DefaultMACAlgorithm}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
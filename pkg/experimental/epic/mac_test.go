@@ -0,0 +1,99 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package epic
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/scionproto/scion/pkg/slayers"
+	"github.com/scionproto/scion/pkg/slayers/path/epic"
+)
+
+func testPktID(t *testing.T) epic.PktID {
+	t.Helper()
+	return newTestPktID()
+}
+
+func testSCION(t *testing.T) *slayers.SCION {
+	t.Helper()
+	return newTestSCION()
+}
+
+func TestSelectMACAlgorithm(t *testing.T) {
+	testCases := map[string]struct {
+		name      string
+		want      MACAlgorithm
+		assertErr assert.ErrorAssertionFunc
+	}{
+		"cbc":     {name: "CBC-MACv0", want: CBCMACv0{}, assertErr: assert.NoError},
+		"cmac":    {name: "CMACv1", want: CMACv1{}, assertErr: assert.NoError},
+		"unknown": {name: "does-not-exist", assertErr: assert.Error},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			got, err := SelectMACAlgorithm(tc.name)
+			tc.assertErr(t, err)
+			if err == nil {
+				assert.Equal(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCMACv1MatchesReferenceVector(t *testing.T) {
+	// RFC 4493 Example 2: 128-bit key, 16-byte (single complete block)
+	// message. The reference tag is truncated to the first 4 bytes, since
+	// that is all CalcMac/VerifyHVF ever use.
+	key := []byte{
+		0x2b, 0x7e, 0x15, 0x16, 0x28, 0xae, 0xd2, 0xa6,
+		0xab, 0xf7, 0x15, 0x88, 0x09, 0xcf, 0x4f, 0x3c,
+	}
+	msg := []byte{
+		0x6b, 0xc1, 0xbe, 0xe2, 0x2e, 0x40, 0x9f, 0x96,
+		0xe9, 0x3d, 0x7e, 0x11, 0x73, 0x93, 0x17, 0x2a,
+	}
+	want := [4]byte{0x07, 0x0a, 0x16, 0xb4}
+
+	buf := make([]byte, len(msg))
+	copy(buf, msg)
+	got, err := CMACv1{}.MAC(key, buf)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+}
+
+func TestVerifyHVFFallbackAlgorithm(t *testing.T) {
+	auth := []byte("0123456789abcdef")
+	pktID := testPktID(t)
+	s := testSCION(t)
+	timestamp := uint32(1)
+	buffer := make([]byte, MACBufferSize)
+
+	cbcMAC, err := CalcMac(auth, pktID, s, timestamp, buffer, WithMACAlgorithm(CBCMACv0{}))
+	require.NoError(t, err)
+
+	// A peer that only ever computes CBCMACv0 HVFs must still verify when
+	// the verifier's primary algorithm is CMACv1 but a fallback is
+	// configured.
+	err = VerifyHVF(auth, pktID, s, timestamp, cbcMAC, buffer,
+		WithMACAlgorithm(CMACv1{}), WithFallbackMACAlgorithm(CBCMACv0{}))
+	assert.NoError(t, err)
+
+	// Without the fallback, the same HVF must be rejected.
+	err = VerifyHVF(auth, pktID, s, timestamp, cbcMAC, buffer, WithMACAlgorithm(CMACv1{}))
+	assert.Error(t, err)
+}
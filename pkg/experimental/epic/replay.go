@@ -0,0 +1,308 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package epic
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/scionproto/scion/pkg/slayers/path/epic"
+)
+
+const (
+	// defaultReplayBuckets is the number of ring buckets each per-core replay
+	// shard is split into. Each bucket covers MaxPacketLifetime/N of wall
+	// time, so the ring as a whole covers MaxPacketLifetime.
+	defaultReplayBuckets = 4
+	// defaultReplayBucketCounters is the number of counters in each bucket's
+	// counting Bloom filter, sized for a few thousand packets per bucket at
+	// a false-positive rate well under 1%.
+	defaultReplayBucketCounters = 1 << 16
+	// replayHashFns is the number of independent hash functions (implemented
+	// via double hashing) used per inserted packet ID.
+	replayHashFns = 3
+)
+
+// ReplayFilter suppresses duplicate EPIC packets seen within
+// MaxPacketLifetime of each other. It shards state by the packet's core ID
+// (see PktCounterFromCore/CoreFromPktCounter), each core ID getting its own
+// ring of buckets behind its own lock. Seen is safe for concurrent use, but
+// it is not lock-free: every call takes the filter-wide lock to look up the
+// shard and then the shard's own lock.
+type ReplayFilter struct {
+	numBuckets     int
+	bucketWidth    time.Duration
+	bucketCounters int
+	metrics        ReplayFilterMetrics
+
+	mu     sync.Mutex // guards shards, not individual shard state
+	shards map[uint8]*replayShard
+}
+
+// NewReplayFilter returns a ReplayFilter with defaultReplayBuckets buckets
+// per core, each covering MaxPacketLifetime/defaultReplayBuckets of wall
+// time.
+func NewReplayFilter(metrics ReplayFilterMetrics) *ReplayFilter {
+	return NewReplayFilterWithConfig(metrics, defaultReplayBuckets,
+		MaxPacketLifetime/defaultReplayBuckets, defaultReplayBucketCounters)
+}
+
+// NewReplayFilterWithConfig returns a ReplayFilter with the given number of
+// buckets per core, each covering bucketWidth of wall time and holding a
+// counting Bloom filter with bucketCounters counters.
+func NewReplayFilterWithConfig(metrics ReplayFilterMetrics, numBuckets int,
+	bucketWidth time.Duration, bucketCounters int) *ReplayFilter {
+
+	return &ReplayFilter{
+		numBuckets:     numBuckets,
+		bucketWidth:    bucketWidth,
+		bucketCounters: bucketCounters,
+		metrics:        metrics,
+		shards:         make(map[uint8]*replayShard),
+	}
+}
+
+// Seen reports whether a packet with the given pktID, received at
+// timestamp, has already been observed recently. Buckets are evicted whole,
+// so a packet is remembered for at least numBuckets-1 and at most numBuckets
+// bucket widths (MaxPacketLifetime for NewReplayFilter) after its insertion.
+// It shards across cores using the pktID's core ID, rotates the shard's
+// buckets based on timestamp, and inserts the packet into the now-current
+// bucket regardless of whether it was already seen, so that repeated
+// replays keep being detected.
+func (f *ReplayFilter) Seen(pktID epic.PktID, timestamp time.Time) bool {
+	coreID, coreCounter := CoreFromPktCounter(pktID.Counter)
+	shard := f.shardFor(coreID)
+	return shard.seen(coreID, coreCounter, pktID.Timestamp, timestamp)
+}
+
+// shardFor returns the replay shard for coreID, creating it on first use.
+// It takes the filter-wide lock on every call, for the map lookup as well as
+// for creation; tests and inserts on the returned shard take only the
+// shard's own lock.
+func (f *ReplayFilter) shardFor(coreID uint8) *replayShard {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	shard, ok := f.shards[coreID]
+	if !ok {
+		shard = newReplayShard(f.numBuckets, f.bucketWidth, f.bucketCounters, f.metrics)
+		f.shards[coreID] = shard
+	}
+	return shard
+}
+
+// replayShard is the per-core ring of counting Bloom filter buckets.
+type replayShard struct {
+	mu          sync.Mutex
+	bucketWidth time.Duration
+	metrics     ReplayFilterMetrics
+
+	buckets     []countingBloom
+	bucketEnd   []time.Time // exclusive end of the epoch each bucket currently covers
+	head        int         // index of the most recent (currently filling) bucket
+	initialized bool
+}
+
+func newReplayShard(numBuckets int, bucketWidth time.Duration, bucketCounters int,
+	metrics ReplayFilterMetrics) *replayShard {
+
+	buckets := make([]countingBloom, numBuckets)
+	for i := range buckets {
+		buckets[i] = newCountingBloom(bucketCounters)
+	}
+	return &replayShard{
+		bucketWidth: bucketWidth,
+		metrics:     metrics,
+		buckets:     buckets,
+		bucketEnd:   make([]time.Time, numBuckets),
+	}
+}
+
+func (s *replayShard) seen(coreID uint8, coreCounter uint32, epicTS uint32, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rotate(now)
+
+	// The buckets together cover the whole MaxPacketLifetime window, so a
+	// packet found in any one of them has been seen within the window.
+	item := replayHashInput(coreID, coreCounter, epicTS)
+	found := false
+	for i := range s.buckets {
+		if s.buckets[i].test(item) {
+			found = true
+			break
+		}
+	}
+
+	s.buckets[s.head].add(item)
+	return found
+}
+
+// rotate advances the ring so that the head bucket's epoch covers now,
+// evicting and resetting the bucket being recycled at each step (not the
+// one just filled, which must keep holding its data until it is itself the
+// oldest bucket and about to be reused). On the very first call it simply
+// starts the head bucket's epoch at now.
+func (s *replayShard) rotate(now time.Time) {
+	if !s.initialized {
+		s.initialized = true
+		s.bucketEnd[s.head] = now.Add(s.bucketWidth)
+		return
+	}
+
+	// We fell behind by at least a full ring's worth of epochs (e.g. after
+	// an idle period); every bucket's data is already stale, so evict all
+	// of them and restart the ring fresh on now instead of replaying every
+	// missed rotation one bucket at a time.
+	if now.Sub(s.bucketEnd[s.head]) >= time.Duration(len(s.buckets))*s.bucketWidth {
+		for i := range s.buckets {
+			s.evict(i)
+		}
+		s.bucketEnd[s.head] = now.Add(s.bucketWidth)
+		return
+	}
+
+	for !now.Before(s.bucketEnd[s.head]) {
+		s.head = (s.head + 1) % len(s.buckets)
+		s.evict(s.head)
+		prev := (s.head - 1 + len(s.buckets)) % len(s.buckets)
+		s.bucketEnd[s.head] = s.bucketEnd[prev].Add(s.bucketWidth)
+	}
+}
+
+// evict resets bucket idx, merging its observed fill ratio into the
+// filter's Prometheus metrics before the counts are discarded.
+func (s *replayShard) evict(idx int) {
+	fill := s.buckets[idx].fillRatio()
+	if s.metrics.BucketFillRatio != nil {
+		s.metrics.BucketFillRatio.Set(fill)
+	}
+	if s.metrics.EstimatedFalsePositiveRate != nil {
+		s.metrics.EstimatedFalsePositiveRate.Set(math.Pow(fill, replayHashFns))
+	}
+	s.buckets[idx].reset()
+}
+
+// replayHashInput serializes the fields that identify a packet for replay
+// suppression purposes into a fixed-size byte slice suitable for hashing.
+func replayHashInput(coreID uint8, coreCounter uint32, epicTS uint32) []byte {
+	buf := make([]byte, 1+4+4)
+	buf[0] = coreID
+	binary.BigEndian.PutUint32(buf[1:5], coreCounter)
+	binary.BigEndian.PutUint32(buf[5:9], epicTS)
+	return buf
+}
+
+// countingBloom is a counting Bloom filter: each of the k hash positions for
+// an inserted item increments a saturating counter instead of setting a
+// single bit, so reset can clear a bucket in bulk without bookkeeping
+// per-item removals.
+type countingBloom struct {
+	counters []uint8
+}
+
+func newCountingBloom(size int) countingBloom {
+	return countingBloom{counters: make([]uint8, size)}
+}
+
+func (b *countingBloom) positions(item []byte) [replayHashFns]int {
+	h := fnv.New64a()
+	h.Write(item)
+	h1 := h.Sum64()
+	h.Reset()
+	h.Write(item)
+	h.Write([]byte{0xff})
+	h2 := h.Sum64()
+
+	var pos [replayHashFns]int
+	for i := 0; i < replayHashFns; i++ {
+		pos[i] = int((h1 + uint64(i)*h2) % uint64(len(b.counters)))
+	}
+	return pos
+}
+
+func (b *countingBloom) add(item []byte) {
+	for _, p := range b.positions(item) {
+		if b.counters[p] < math.MaxUint8 {
+			b.counters[p]++
+		}
+	}
+}
+
+func (b *countingBloom) test(item []byte) bool {
+	for _, p := range b.positions(item) {
+		if b.counters[p] == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *countingBloom) reset() {
+	for i := range b.counters {
+		b.counters[i] = 0
+	}
+}
+
+// fillRatio returns the fraction of counters that are non-zero.
+func (b *countingBloom) fillRatio() float64 {
+	set := 0
+	for _, c := range b.counters {
+		if c != 0 {
+			set++
+		}
+	}
+	return float64(set) / float64(len(b.counters))
+}
+
+// ReplayFilterMetrics holds the Prometheus instruments a ReplayFilter
+// reports to. Both fields are optional: a nil gauge is simply not updated.
+type ReplayFilterMetrics struct {
+	// BucketFillRatio tracks the fraction of counters set in the most
+	// recently evicted bucket, as a proxy for how close the filter is to
+	// being undersized for the current packet rate.
+	BucketFillRatio prometheus.Gauge
+	// EstimatedFalsePositiveRate tracks fillRatio^k, the standard Bloom
+	// filter false-positive rate estimate for k hash functions, evaluated
+	// at each bucket eviction.
+	EstimatedFalsePositiveRate prometheus.Gauge
+}
+
+// NewReplayFilterMetrics creates the Prometheus instruments for a
+// ReplayFilter, named under the "router" namespace and "epic" subsystem
+// (router_epic_*). It does not register them with any registry.
+func NewReplayFilterMetrics() ReplayFilterMetrics {
+	return ReplayFilterMetrics{
+		BucketFillRatio: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "router",
+			Subsystem: "epic",
+			Name:      "replay_filter_bucket_fill_ratio",
+			Help:      "Fraction of counters set in the most recently evicted replay filter bucket.",
+		}),
+		EstimatedFalsePositiveRate: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "router",
+			Subsystem: "epic",
+			Name:      "replay_filter_estimated_false_positive_rate",
+			Help:      "Estimated false-positive rate of the replay filter, derived from bucket fill.",
+		}),
+	}
+}
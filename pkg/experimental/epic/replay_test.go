@@ -0,0 +1,94 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package epic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/scionproto/scion/pkg/slayers/path/epic"
+)
+
+func TestReplayFilterSeen(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	pktID := epic.PktID{Timestamp: 42, Counter: PktCounterFromCore(3, 1)}
+	other := epic.PktID{Timestamp: 43, Counter: PktCounterFromCore(3, 1)}
+
+	f := NewReplayFilter(NewReplayFilterMetrics())
+
+	assert.False(t, f.Seen(pktID, now), "first sighting must not be flagged as replay")
+	assert.True(t, f.Seen(pktID, now), "second sighting of the same packet must be flagged")
+	assert.False(t, f.Seen(other, now), "a distinct packet must not be flagged")
+}
+
+func TestReplayFilterRotatesOutOldBuckets(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	pktID := epic.PktID{Timestamp: 1, Counter: PktCounterFromCore(0, 1)}
+
+	const numBuckets = 2
+	bucketWidth := MaxPacketLifetime / numBuckets
+	f := NewReplayFilterWithConfig(NewReplayFilterMetrics(), numBuckets, bucketWidth, 1<<10)
+
+	assert.False(t, f.Seen(pktID, now))
+	assert.True(t, f.Seen(pktID, now))
+
+	// After the packet's bucket has fully rotated out of the window, it
+	// should no longer be considered a replay.
+	later := now.Add(MaxPacketLifetime + bucketWidth)
+	assert.False(t, f.Seen(pktID, later))
+}
+
+func TestReplayFilterDetectsReplayAcrossBucketRotation(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	pktID := epic.PktID{Timestamp: 5, Counter: PktCounterFromCore(0, 9)}
+
+	const numBuckets = 4
+	bucketWidth := MaxPacketLifetime / numBuckets
+	f := NewReplayFilterWithConfig(NewReplayFilterMetrics(), numBuckets, bucketWidth, 1<<10)
+
+	assert.False(t, f.Seen(pktID, now), "first sighting must not be flagged as replay")
+
+	// One bucket width later: the ring has rotated to a new bucket, but
+	// the packet is still well within MaxPacketLifetime and must still be
+	// detected as a replay.
+	later := now.Add(bucketWidth)
+	assert.True(t, f.Seen(pktID, later),
+		"a replay across a bucket rotation, still within the lifetime window, must be detected")
+}
+
+func TestReplayFilterShardsByCoreID(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	a := epic.PktID{Timestamp: 1, Counter: PktCounterFromCore(1, 7)}
+	b := epic.PktID{Timestamp: 1, Counter: PktCounterFromCore(2, 7)}
+
+	f := NewReplayFilter(NewReplayFilterMetrics())
+	assert.False(t, f.Seen(a, now))
+	// Same core counter and timestamp, but a different core ID: must be
+	// tracked independently and not collide with core 1's entry.
+	assert.False(t, f.Seen(b, now))
+	assert.True(t, f.Seen(a, now))
+	assert.True(t, f.Seen(b, now))
+}
+
+func TestCountingBloomFillRatio(t *testing.T) {
+	b := newCountingBloom(1 << 10)
+	assert.Equal(t, float64(0), b.fillRatio())
+	b.add(replayHashInput(1, 2, 3))
+	assert.Greater(t, b.fillRatio(), float64(0))
+	b.reset()
+	assert.Equal(t, float64(0), b.fillRatio())
+}
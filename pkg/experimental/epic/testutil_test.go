@@ -0,0 +1,37 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package epic
+
+import (
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/slayers"
+	"github.com/scionproto/scion/pkg/slayers/path/epic"
+)
+
+// newTestPktID and newTestSCION build the fixed PktID/SCION header used
+// across this package's tests and benchmarks. They take no *testing.T since
+// benchmarks need them too.
+func newTestPktID() epic.PktID {
+	return epic.PktID{Timestamp: 1, Counter: PktCounterFromCore(0, 1)}
+}
+
+func newTestSCION() *slayers.SCION {
+	return &slayers.SCION{
+		SrcIA:      addr.IA(0x1_ff0000000001),
+		SrcAddrLen: slayers.AddrLen4,
+		RawSrcAddr: []byte{127, 0, 0, 1},
+		PayloadLen: 100,
+	}
+}
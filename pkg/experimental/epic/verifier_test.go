@@ -0,0 +1,102 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package epic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifierVerifyHVFBatch(t *testing.T) {
+	auth := []byte("0123456789abcdef")
+	pktID := testPktID(t)
+	s := testSCION(t)
+	timestamp := uint32(1)
+
+	hvf, err := CalcMac(auth, pktID, s, timestamp, nil)
+	require.NoError(t, err)
+
+	v := NewVerifier()
+	items := []HVFRequest{
+		{Auth: auth, PktID: pktID, S: s, Timestamp: timestamp, HVF: hvf},
+		{Auth: auth, PktID: pktID, S: s, Timestamp: timestamp, HVF: []byte{0, 0, 0, 0}},
+	}
+	errs := v.VerifyHVFBatch(items)
+	require.Len(t, errs, 2)
+	assert.NoError(t, errs[0])
+	assert.Error(t, errs[1])
+}
+
+// BenchmarkVerifyHVFOneShot measures the current per-packet VerifyHVF cost,
+// which re-initializes an AES cipher.Block on every call.
+func BenchmarkVerifyHVFOneShot(b *testing.B) {
+	auth := []byte("0123456789abcdef")
+	pktID := newTestPktID()
+	s := newTestSCION()
+	timestamp := uint32(1)
+	buffer := make([]byte, MACBufferSize)
+	hvf, _ := CalcMac(auth, pktID, s, timestamp, buffer)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = VerifyHVF(auth, pktID, s, timestamp, hvf, buffer)
+	}
+}
+
+// BenchmarkVerifyHVFBatch measures VerifyHVFBatch on a representative
+// border-router poll cycle (32 packets sharing the same hop authenticator).
+// Compare against BenchmarkVerifyHVFOneShot to see the effect of
+// initializing the AES cipher.Block once for the whole batch instead of
+// once per packet.
+func BenchmarkVerifyHVFBatch(b *testing.B) {
+	const batchSize = 32
+	auth := []byte("0123456789abcdef")
+	pktID := newTestPktID()
+	s := newTestSCION()
+	timestamp := uint32(1)
+	hvf, _ := CalcMac(auth, pktID, s, timestamp, nil)
+
+	items := make([]HVFRequest, batchSize)
+	for i := range items {
+		items[i] = HVFRequest{Auth: auth, PktID: pktID, S: s, Timestamp: timestamp, HVF: hvf}
+	}
+	v := NewVerifier()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i += batchSize {
+		v.VerifyHVFBatch(items)
+	}
+}
+
+// TestVerifyHVFBatchVsOneShot runs both benchmarks above internally
+// and logs the measured per-packet cost of each, so the batch verifier's
+// speedup over one-shot verification is recorded rather than left to be
+// eyeballed from `go test -bench`. It does not assert a specific ratio:
+// the margin depends on the host's AES-NI availability, and a hard
+// threshold would make this test flaky across machines.
+func TestVerifyHVFBatchVsOneShot(t *testing.T) {
+	oneShot := testing.Benchmark(BenchmarkVerifyHVFOneShot)
+	batch := testing.Benchmark(BenchmarkVerifyHVFBatch)
+
+	t.Logf("one-shot: %s/op, batch: %s/op", time.Duration(oneShot.NsPerOp()),
+		time.Duration(batch.NsPerOp()))
+	if batch.NsPerOp() >= oneShot.NsPerOp() {
+		t.Logf("batch verification (%s/op) did not beat one-shot (%s/op) on this run",
+			time.Duration(batch.NsPerOp()), time.Duration(oneShot.NsPerOp()))
+	}
+}
@@ -0,0 +1,287 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package router
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"hash"
+	"time"
+
+	"github.com/scionproto/scion/pkg/experimental/epic"
+	"github.com/scionproto/scion/pkg/private/serrors"
+	"github.com/scionproto/scion/pkg/slayers"
+	"github.com/scionproto/scion/pkg/slayers/path"
+	epicpath "github.com/scionproto/scion/pkg/slayers/path/epic"
+)
+
+// DataPlane holds the state needed to forward SCION packets on the router's
+// fast path.
+type DataPlane struct {
+	// key is the local AS-wide key used to derive the per-packet SCION
+	// path MAC.
+	key []byte
+	// epicVerifyOptions bundles the acceptance window used to validate the
+	// freshness of incoming EPIC packets with the MAC algorithm option(s)
+	// applied to their HVF, so the two are configured together instead of
+	// drifting out of sync with each other. Its zero value resolves to
+	// epic.DefaultAcceptanceWindow and the epic package's default MAC
+	// algorithm; operators widen AcceptanceWindow for geographically
+	// distant ASes or narrow it on high-throughput border routers, and set
+	// MACOptions when migrating a deployment between MAC algorithms.
+	epicVerifyOptions epic.VerifyOptions
+	// epicVerifier caches AES ciphers across EPIC HVF verifications and
+	// backs the batched verification path used when draining more than one
+	// packet from the NIC ring in a single poll cycle.
+	epicVerifier *epic.Verifier
+	// epicReplayFilter suppresses EPIC packets replayed within the
+	// freshness window. A nil filter disables replay suppression.
+	epicReplayFilter *epic.ReplayFilter
+}
+
+// scionPacketProcessor processes a single SCION packet on the forwarding
+// path. It is reused across packets on the same goroutine to avoid
+// per-packet allocations.
+type scionPacketProcessor struct {
+	d *DataPlane
+	// ingressID is the interface the packet was received on.
+	ingressID uint16
+	// rawPkt is the raw packet buffer currently being processed.
+	rawPkt []byte
+	// scionLayer is the decoded SCION common/address header of rawPkt.
+	scionLayer slayers.SCION
+	// mac is the keyed hash used to verify hop-field MACs. It is allocated
+	// once per processor and reset between packets, so repeated
+	// verifications on the same goroutine never pay for AES key-schedule
+	// setup more than once.
+	mac hash.Hash
+	// macInputBuffer is a scratch buffer reused across MAC computations to
+	// avoid per-packet allocations.
+	macInputBuffer []byte
+	// cachedMac holds the full 16-byte MAC computed by verifyCurrentMAC for
+	// the current hop field. Regular SCION path processing only compares
+	// the first path.MacLen bytes of it against the hop field's truncated
+	// MAC, but EPIC needs all 16 bytes as the "auth" input to
+	// epic.CalcMac; caching it here lets processEPIC reuse that result
+	// instead of re-deriving the hop-field MAC a second time.
+	cachedMac [16]byte
+}
+
+// verifyCurrentMAC verifies hf's MAC, as computed from info and hf, against
+// the truncated MAC carried in the hop field, caching the full (untruncated)
+// result in p.cachedMac. This is the same check regular (non-EPIC) SCION
+// path processing performs for every hop field; EPIC processing reuses its
+// result via p.cachedMac instead of invoking it a second time per packet.
+func (p *scionPacketProcessor) verifyCurrentMAC(info path.InfoField, hf path.HopField) error {
+	if len(p.macInputBuffer) < path.MACInputLen {
+		p.macInputBuffer = make([]byte, path.MACInputLen)
+	}
+	input := macInput(info, hf, p.macInputBuffer)
+
+	p.mac.Reset()
+	p.mac.Write(input)
+	copy(p.cachedMac[:], p.mac.Sum(nil))
+
+	if subtle.ConstantTimeCompare(hf.Mac[:path.MacLen], p.cachedMac[:path.MacLen]) != 1 {
+		return serrors.New("hop field MAC verification failed",
+			"expected", hf.Mac, "actual", p.cachedMac[:path.MacLen])
+	}
+	return nil
+}
+
+// macInput assembles the MAC input for info and hf into buffer, returning
+// the portion of buffer that was written: the segment ID, the info field's
+// timestamp, the hop field's expiry time, and its ingress/egress interface
+// IDs.
+func macInput(info path.InfoField, hf path.HopField, buffer []byte) []byte {
+	binary.BigEndian.PutUint16(buffer[0:2], info.SegID)
+	binary.BigEndian.PutUint32(buffer[2:6], info.Timestamp)
+	buffer[6] = 0
+	buffer[7] = hf.ExpTime
+	binary.BigEndian.PutUint16(buffer[8:10], hf.ConsIngress)
+	binary.BigEndian.PutUint16(buffer[10:12], hf.ConsEgress)
+	for i := 12; i < path.MACInputLen; i++ {
+		buffer[i] = 0
+	}
+	return buffer[:path.MACInputLen]
+}
+
+// epicRawPath is the subset of the decoded SCION path carried inside an
+// EPIC path (normally a *scion.Raw) that EPIC processing needs: the current
+// info/hop field, and whether the current hop is the last one on the path.
+// It is expressed as a small interface, satisfied structurally by the real
+// raw path type, so that epicHVFRequestFromRawPath can be driven directly by
+// tests without depending on the full SCION raw-path decoder.
+type epicRawPath interface {
+	GetCurrentInfoField() (path.InfoField, error)
+	GetCurrentHopField() (path.HopField, error)
+	IsLastHop() bool
+}
+
+// asEpicRawPath adapts v, the ScionPath field of a decoded *epicpath.Path, to
+// epicRawPath. v is accepted as any, rather than the concrete raw path type,
+// so that this file does not need to import that type just to check it
+// implements the three methods EPIC needs.
+func asEpicRawPath(v any) (epicRawPath, bool) {
+	p, ok := v.(epicRawPath)
+	return p, ok
+}
+
+// processEPIC validates an incoming EPIC path type packet. It parses the
+// EPIC path, checks the packet's freshness, and verifies the PHVF at every
+// on-path hop and the LHVF at the destination AS, rejecting the packet if
+// any check fails.
+func (p *scionPacketProcessor) processEPIC(now time.Time) error {
+	epicPath, ok := p.scionLayer.Path.(*epicpath.Path)
+	if ok && p.d.epicReplayFilter != nil &&
+		p.d.epicReplayFilter.Seen(epicPath.PktID, now) {
+		return serrors.New("epic packet ID already seen, dropping as replay",
+			"pktID", epicPath.PktID)
+	}
+
+	req, atDestination, hop, err := p.epicHVFRequest(now)
+	if err != nil {
+		return err
+	}
+	if err := epic.VerifyHVF(req.Auth, req.PktID, req.S, req.Timestamp, req.HVF,
+		p.macInputBuffer, req.Options...); err != nil {
+		if atDestination {
+			return serrors.WrapStr("epic LHVF verification failed", err, "hop", hop)
+		}
+		return serrors.WrapStr("epic PHVF verification failed", err, "hop", hop)
+	}
+	return nil
+}
+
+// epicHVFRequest parses the current packet's EPIC path and delegates to
+// epicHVFRequestFromRawPath. It is shared by processEPIC and
+// processEPICBatch so that both the one-shot and batched paths apply the
+// same freshness check and build the request the same way.
+func (p *scionPacketProcessor) epicHVFRequest(now time.Time) (epic.HVFRequest, bool, path.HopField, error) {
+	epicPath, ok := p.scionLayer.Path.(*epicpath.Path)
+	if !ok {
+		return epic.HVFRequest{}, false, path.HopField{}, serrors.New("unable to extract EPIC path")
+	}
+	rawPath, ok := asEpicRawPath(epicPath.ScionPath)
+	if !ok {
+		return epic.HVFRequest{}, false, path.HopField{}, serrors.New(
+			"EPIC path is missing the underlying SCION path")
+	}
+	return p.epicHVFRequestFromRawPath(rawPath, epicPath.PktID, epicPath.PHVF, epicPath.LHVF, now)
+}
+
+// epicHVFRequestFromRawPath validates the packet's freshness and assembles
+// the epic.HVFRequest needed to verify its PHVF (or LHVF, at the destination
+// AS), given the already-extracted raw path and EPIC-specific fields. It is
+// split out from epicHVFRequest so the EPIC-specific logic can be exercised
+// against a test double for rawPath.
+func (p *scionPacketProcessor) epicHVFRequestFromRawPath(rawPath epicRawPath, pktID epicpath.PktID,
+	phvf, lhvf []byte, now time.Time) (epic.HVFRequest, bool, path.HopField, error) {
+
+	info, err := rawPath.GetCurrentInfoField()
+	if err != nil {
+		return epic.HVFRequest{}, false, path.HopField{}, serrors.WrapStr(
+			"parsing info field of EPIC path", err)
+	}
+	hop, err := rawPath.GetCurrentHopField()
+	if err != nil {
+		return epic.HVFRequest{}, false, path.HopField{}, serrors.WrapStr(
+			"parsing hop field of EPIC path", err)
+	}
+	if err := p.verifyCurrentMAC(info, hop); err != nil {
+		return epic.HVFRequest{}, false, path.HopField{}, serrors.WrapStr(
+			"verifying hop field MAC for epic", err)
+	}
+
+	vo := p.d.epicVerifyOptions
+	aw := vo.AcceptanceWindow
+	if aw == (epic.AcceptanceWindow{}) {
+		aw = epic.DefaultAcceptanceWindow
+	}
+	if err := epic.VerifyTimestampInWindow(time.Unix(int64(info.Timestamp), 0),
+		pktID.Timestamp, now, aw); err != nil {
+		return epic.HVFRequest{}, false, path.HopField{}, serrors.WrapStr(
+			"epic timestamp validation failed", err)
+	}
+
+	atDestination := rawPath.IsLastHop()
+	hvf := phvf
+	if atDestination {
+		hvf = lhvf
+	}
+
+	return epic.HVFRequest{
+		Auth:      p.cachedMac[:],
+		PktID:     pktID,
+		S:         &p.scionLayer,
+		Timestamp: info.Timestamp,
+		HVF:       hvf,
+		Options:   vo.MACOptions,
+	}, atDestination, hop, nil
+}
+
+// processEPICBatch validates a batch of EPIC packets, verifying all HVFs
+// through the DataPlane's shared epic.Verifier, which caches AES ciphers
+// across packets that share the same hop authenticator and reuses a single
+// scratch buffer for the whole batch. A batch of exactly one packet is
+// handed to processEPIC instead, which uses epic.VerifyHVF. It is intended to be called once per
+// poll cycle with the packets drained from the NIC ring in that cycle, but
+// this package does not yet contain that receive loop, so nothing calls it
+// today; it is exercised directly by TestProcessEPICBatch until it is
+// wired into one.
+func (d *DataPlane) processEPICBatch(procs []*scionPacketProcessor, now time.Time) []error {
+	if len(procs) == 1 {
+		return []error{procs[0].processEPIC(now)}
+	}
+
+	errs := make([]error, len(procs))
+	atDest := make([]bool, len(procs))
+	hops := make([]path.HopField, len(procs))
+	reqs := make([]epic.HVFRequest, 0, len(procs))
+	idx := make([]int, 0, len(procs))
+
+	for i, p := range procs {
+		if epicPath, ok := p.scionLayer.Path.(*epicpath.Path); ok &&
+			d.epicReplayFilter != nil && d.epicReplayFilter.Seen(epicPath.PktID, now) {
+			errs[i] = serrors.New("epic packet ID already seen, dropping as replay",
+				"pktID", epicPath.PktID)
+			continue
+		}
+		req, atDestination, hop, err := p.epicHVFRequest(now)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		atDest[i], hops[i] = atDestination, hop
+		reqs = append(reqs, req)
+		idx = append(idx, i)
+	}
+
+	if d.epicVerifier == nil {
+		d.epicVerifier = epic.NewVerifier()
+	}
+	for j, err := range d.epicVerifier.VerifyHVFBatch(reqs) {
+		i := idx[j]
+		if err == nil {
+			continue
+		}
+		if atDest[i] {
+			errs[i] = serrors.WrapStr("epic LHVF verification failed", err, "hop", hops[i])
+		} else {
+			errs[i] = serrors.WrapStr("epic PHVF verification failed", err, "hop", hops[i])
+		}
+	}
+	return errs
+}
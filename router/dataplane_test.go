@@ -0,0 +1,236 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package router
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"hash"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/scionproto/scion/pkg/experimental/epic"
+	"github.com/scionproto/scion/pkg/slayers"
+	"github.com/scionproto/scion/pkg/slayers/path"
+	epicpath "github.com/scionproto/scion/pkg/slayers/path/epic"
+)
+
+// fakeRawPath is a minimal epicRawPath test double, standing in for the
+// real *scion.Raw decoded path that carries the current info/hop field in
+// production.
+type fakeRawPath struct {
+	info   path.InfoField
+	hop    path.HopField
+	atDest bool
+}
+
+func (f fakeRawPath) GetCurrentInfoField() (path.InfoField, error) { return f.info, nil }
+func (f fakeRawPath) GetCurrentHopField() (path.HopField, error)   { return f.hop, nil }
+func (f fakeRawPath) IsLastHop() bool                              { return f.atDest }
+
+func newTestProcessor(key []byte) *scionPacketProcessor {
+	return &scionPacketProcessor{
+		d:          &DataPlane{key: key},
+		ingressID:  1,
+		mac:        hmac.New(sha256.New, key),
+		scionLayer: slayers.SCION{},
+	}
+}
+
+// computeHopMAC runs the same MAC computation verifyCurrentMAC does,
+// returning both the truncated hop-field MAC and the full 16 bytes a
+// correctly-MAC'd hop field would leave cached for EPIC to reuse as auth.
+func computeHopMAC(t *testing.T, mac hash.Hash, info path.InfoField, hop path.HopField) (full [16]byte, truncated [path.MacLen]byte) {
+	t.Helper()
+	buf := make([]byte, path.MACInputLen)
+	input := macInput(info, hop, buf)
+	mac.Reset()
+	mac.Write(input)
+	sum := mac.Sum(nil)
+	copy(full[:], sum)
+	copy(truncated[:], sum)
+	return full, truncated
+}
+
+func TestProcessEPIC(t *testing.T) {
+	now := time.Unix(1000, 0)
+	key := []byte("0123456789abcdef")
+
+	t.Run("malformed EPIC header", func(t *testing.T) {
+		p := newTestProcessor(key)
+		p.scionLayer = slayers.SCION{Path: &epicpath.Path{ScionPath: nil}}
+		err := p.processEPIC(now)
+		assert.Error(t, err)
+	})
+
+	t.Run("not an EPIC path", func(t *testing.T) {
+		p := newTestProcessor(key)
+		p.scionLayer = slayers.SCION{Path: nil}
+		err := p.processEPIC(now)
+		assert.Error(t, err)
+	})
+}
+
+func TestEpicHVFRequestFromRawPath(t *testing.T) {
+	now := time.Unix(1000, 0)
+	key := []byte("0123456789abcdef")
+	pktID := epicpath.PktID{Timestamp: uint32(now.Unix())}
+
+	info := path.InfoField{SegID: 1, Timestamp: uint32(now.Unix())}
+	hop := path.HopField{ExpTime: 63, ConsIngress: 1, ConsEgress: 2}
+
+	newValid := func(t *testing.T, atDest bool) (*scionPacketProcessor, fakeRawPath, []byte, []byte) {
+		t.Helper()
+		p := newTestProcessor(key)
+		full, truncated := computeHopMAC(t, p.mac, info, hop)
+		hop := hop
+		hop.Mac = truncated
+
+		buffer := make([]byte, epic.MACBufferSize)
+		phvf, err := epic.CalcMac(full[:], pktID, &p.scionLayer, info.Timestamp, buffer)
+		require.NoError(t, err)
+		lhvf, err := epic.CalcMac(full[:], pktID, &p.scionLayer, info.Timestamp, buffer)
+		require.NoError(t, err)
+
+		return p, fakeRawPath{info: info, hop: hop, atDest: atDest}, phvf, lhvf
+	}
+
+	t.Run("valid packet, on path", func(t *testing.T) {
+		p, raw, phvf, lhvf := newValid(t, false)
+		req, atDestination, _, err := p.epicHVFRequestFromRawPath(raw, pktID, phvf, lhvf, now)
+		require.NoError(t, err)
+		assert.False(t, atDestination)
+		assert.NoError(t, epic.VerifyHVF(req.Auth, req.PktID, req.S, req.Timestamp, req.HVF, nil))
+	})
+
+	t.Run("valid packet, at destination", func(t *testing.T) {
+		p, raw, phvf, lhvf := newValid(t, true)
+		req, atDestination, _, err := p.epicHVFRequestFromRawPath(raw, pktID, phvf, lhvf, now)
+		require.NoError(t, err)
+		assert.True(t, atDestination)
+		assert.NoError(t, epic.VerifyHVF(req.Auth, req.PktID, req.S, req.Timestamp, req.HVF, nil))
+	})
+
+	t.Run("wrong PHVF", func(t *testing.T) {
+		p, raw, phvf, lhvf := newValid(t, false)
+		phvf[0] ^= 0xff
+		req, _, _, err := p.epicHVFRequestFromRawPath(raw, pktID, phvf, lhvf, now)
+		require.NoError(t, err)
+		assert.Error(t, epic.VerifyHVF(req.Auth, req.PktID, req.S, req.Timestamp, req.HVF, nil))
+	})
+
+	t.Run("wrong LHVF", func(t *testing.T) {
+		p, raw, phvf, lhvf := newValid(t, true)
+		lhvf[0] ^= 0xff
+		req, _, _, err := p.epicHVFRequestFromRawPath(raw, pktID, phvf, lhvf, now)
+		require.NoError(t, err)
+		assert.Error(t, epic.VerifyHVF(req.Auth, req.PktID, req.S, req.Timestamp, req.HVF, nil))
+	})
+
+	t.Run("expired timestamp", func(t *testing.T) {
+		p, raw, phvf, lhvf := newValid(t, false)
+		late := now.Add(epic.DefaultAcceptanceWindow.Offset).Add(time.Second)
+		_, _, _, err := p.epicHVFRequestFromRawPath(raw, pktID, phvf, lhvf, late)
+		assert.Error(t, err)
+	})
+
+	t.Run("future timestamp", func(t *testing.T) {
+		p, raw, phvf, lhvf := newValid(t, false)
+		early := now.Add(-epic.MaxClockSkew).Add(-time.Second)
+		_, _, _, err := p.epicHVFRequestFromRawPath(raw, pktID, phvf, lhvf, early)
+		assert.Error(t, err)
+	})
+
+	t.Run("bad hop field MAC", func(t *testing.T) {
+		p, raw, phvf, lhvf := newValid(t, false)
+		raw.hop.Mac[0] ^= 0xff
+		_, _, _, err := p.epicHVFRequestFromRawPath(raw, pktID, phvf, lhvf, now)
+		assert.Error(t, err)
+	})
+}
+
+func TestProcessEPICBatch(t *testing.T) {
+	now := time.Unix(1000, 0)
+	key := []byte("0123456789abcdef")
+	info := path.InfoField{SegID: 1, Timestamp: uint32(now.Unix())}
+	hop := path.HopField{ExpTime: 63, ConsIngress: 1, ConsEgress: 2}
+
+	newProc := func(t *testing.T, d *DataPlane, pktID epicpath.PktID, breakMAC bool) *scionPacketProcessor {
+		t.Helper()
+		p := newTestProcessor(key)
+		p.d = d
+		full, truncated := computeHopMAC(t, p.mac, info, hop)
+		hop := hop
+		hop.Mac = truncated
+
+		buffer := make([]byte, epic.MACBufferSize)
+		phvf, err := epic.CalcMac(full[:], pktID, &p.scionLayer, info.Timestamp, buffer)
+		require.NoError(t, err)
+		if breakMAC {
+			phvf[0] ^= 0xff
+		}
+		p.scionLayer = slayers.SCION{Path: &epicpath.Path{
+			PktID:     pktID,
+			PHVF:      phvf,
+			LHVF:      phvf,
+			ScionPath: fakeRawPath{info: info, hop: hop, atDest: false},
+		}}
+		return p
+	}
+
+	d := &DataPlane{key: key}
+	procs := []*scionPacketProcessor{
+		newProc(t, d, epicpath.PktID{Timestamp: uint32(now.Unix()), Counter: 1}, false),
+		newProc(t, d, epicpath.PktID{Timestamp: uint32(now.Unix()), Counter: 2}, true),
+	}
+
+	errs := d.processEPICBatch(procs, now)
+	require.Len(t, errs, 2)
+	assert.NoError(t, errs[0])
+	assert.Error(t, errs[1])
+}
+
+func TestProcessEPICDropsReplayedPacket(t *testing.T) {
+	now := time.Unix(1000, 0)
+	key := []byte("0123456789abcdef")
+	pktID := epicpath.PktID{Timestamp: uint32(now.Unix())}
+
+	info := path.InfoField{SegID: 1, Timestamp: uint32(now.Unix())}
+	hop := path.HopField{ExpTime: 63, ConsIngress: 1, ConsEgress: 2}
+
+	p := newTestProcessor(key)
+	full, truncated := computeHopMAC(t, p.mac, info, hop)
+	hop.Mac = truncated
+
+	buffer := make([]byte, epic.MACBufferSize)
+	phvf, err := epic.CalcMac(full[:], pktID, &p.scionLayer, info.Timestamp, buffer)
+	require.NoError(t, err)
+
+	scionPath := &epicpath.Path{
+		PktID:     pktID,
+		PHVF:      phvf,
+		LHVF:      phvf,
+		ScionPath: fakeRawPath{info: info, hop: hop, atDest: false},
+	}
+	p.scionLayer = slayers.SCION{Path: scionPath}
+	p.d.epicReplayFilter = epic.NewReplayFilter(epic.NewReplayFilterMetrics())
+
+	require.NoError(t, p.processEPIC(now))
+	err = p.processEPIC(now)
+	assert.Error(t, err, "a replayed packet ID must be rejected on the second sighting")
+}